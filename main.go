@@ -3,7 +3,12 @@ package main
 
 import (
     "context"
+	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"compress/gzip"
+	"container/list"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/json"
 	"flag"
@@ -15,10 +20,16 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"github.com/andybalholm/brotli"
+	"github.com/fsnotify/fsnotify"
+	"github.com/klauspost/compress/zstd"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 )
@@ -56,10 +67,13 @@ type HealthResponse struct {
 
 // Конфигурация сервера
 type ServerConfig struct {
-	LogDirectory string
-	PreviewSize  int64
-	ServerPort   string
-	Encoding     string
+	LogDirectory    string
+	PreviewSize     int64
+	ServerPort      string
+	Encoding        string
+	ArchiveMaxFiles int
+	ArchiveMaxBytes int64
+	Compression     string
 }
 
 // Глобальная конфигурация
@@ -68,6 +82,74 @@ var config ServerConfig
 // Map для кодировок
 var encodings map[string]encoding.Encoding
 
+// Закрывается при получении сигнала остановки, до server.Shutdown —
+// потоковые обработчики (например /api/tail) слушают его, чтобы завершиться сразу
+var shutdownCh = make(chan struct{})
+
+// Момент запуска сервера и стабильный ETag главной страницы — вычисляются один раз
+// в main(), так как шаблон и конфигурация не меняются в течение жизни процесса
+var serverStartTime time.Time
+var indexETag string
+
+// computeIndexETag хэширует встроенный HTML-шаблон вместе с версией и конфигурацией,
+// влияющей на рендер страницы — пересчитывать его на каждый запрос не нужно
+func computeIndexETag() string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%d|%s",
+		htmlTemplate, version, config.LogDirectory, config.PreviewSize, config.Encoding)))
+	return fmt.Sprintf("\"%x\"", hash)
+}
+
+// etagMatches проверяет, есть ли etag среди значений заголовка If-None-Match
+// (поддерживает список через запятую, `*` и слабые валидаторы вида W/"...")
+func etagMatches(header, etag string) bool {
+	if header == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified устанавливает ETag/Last-Modified и, если запрос содержит
+// совпадающий If-None-Match или достаточно свежий If-Modified-Since, сам отвечает
+// 304 Not Modified. Возвращает true, если ответ уже отправлен и обработчику
+// больше ничего делать не нужно.
+//
+// requireETag запрещает короткое замыкание по одному лишь If-Modified-Since: для
+// ресурсов, чей ETag кодирует не только mtime файла, но и выбранный диапазон/кодировку
+// (/api/file), mtime ничего не говорит о том, совпадает ли запрошенный диапазон
+// с тем, что отдавался раньше — такие ответы обязаны сверяться по ETag.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time, requireETag bool) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if etagMatches(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if requireETag {
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		if since, err := http.ParseTime(ims); err == nil && !modTime.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
 func init() {
 	// Инициализируем доступные кодировки
 	encodings = map[string]encoding.Encoding{
@@ -97,6 +179,15 @@ func main() {
 	flag.StringVar(&config.Encoding, "encoding", "utf-8",
 		"Кодировка лог-файлов: utf-8, win1251/cp1251, koi8-r, iso-8859-1, cp866")
 
+	flag.IntVar(&config.ArchiveMaxFiles, "archive-max-files", 1000,
+		"Максимальное число файлов в одном архиве /api/archive (по умолчанию: 1000)")
+
+	flag.Int64Var(&config.ArchiveMaxBytes, "archive-max-bytes", 500*1024*1024,
+		"Максимальный суммарный размер файлов в архиве /api/archive в байтах (по умолчанию: 500 МБ)")
+
+	flag.StringVar(&config.Compression, "compression", "auto",
+		"Режим сжатия ответов: auto (по Accept-Encoding), gzip, br, none")
+
 	flag.Parse()
 
 	// Проверяем поддержку кодировки
@@ -109,6 +200,13 @@ func main() {
 			config.Encoding, strings.Join(supported, ", "))
 	}
 
+	// Проверяем режим сжатия
+	switch config.Compression {
+	case "auto", "gzip", "br", "none":
+	default:
+		log.Fatalf("❌ Неподдерживаемый режим сжатия: %s. Доступные: auto, gzip, br, none", config.Compression)
+	}
+
 	log.Printf("🚀 Web-просмотрщик логов (версия: %s)", version)
 	log.Printf("📁 Каталог логов: %s", config.LogDirectory)
 	log.Printf("📏 Макс. размер для предпросмотра: %d байт (%.2f МБ)",
@@ -126,6 +224,9 @@ func main() {
 		log.Printf("✅ Каталог создан: %s", config.LogDirectory)
 	}
 
+	serverStartTime = time.Now()
+	indexETag = computeIndexETag()
+
 	// Настройка роутинга
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handleIndex)
@@ -133,7 +234,10 @@ func main() {
 	mux.HandleFunc("/api/health", handleHealth)
 	mux.HandleFunc("/api/files", handleFileList)
 	mux.HandleFunc("/api/file", handleFileContent)
+	mux.HandleFunc("/api/detect", handleDetectEncoding)
+	mux.HandleFunc("/api/tail", handleTail)
 	mux.HandleFunc("/api/download", handleFileDownload)
+	mux.HandleFunc("/api/archive", handleArchive)
 	mux.HandleFunc("/api/config", handleConfig)
 
 	// Настройка сервера с таймаутами
@@ -157,6 +261,8 @@ func main() {
 		log.Printf("🛑 Получен сигнал остановки...")
 		log.Printf("⏳ Завершение работы сервера...")
 
+		close(shutdownCh)
+
 		// Создаем контекст с таймаутом для graceful shutdown
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -223,6 +329,136 @@ type TemplateData struct {
 	Encoding     string
 }
 
+// Пулы компрессоров — чтобы горячие эндпоинты (/api/files, /api/health) не
+// аллоцировали новый компрессор на каждый запрос
+var gzipWriterPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+var brotliWriterPool = sync.Pool{New: func() interface{} { return brotli.NewWriter(io.Discard) }}
+var zstdEncoderPool = sync.Pool{New: func() interface{} {
+	enc, _ := zstd.NewWriter(io.Discard)
+	return enc
+}}
+
+func getGzipWriter(w io.Writer) *gzip.Writer {
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(w)
+	return gz
+}
+
+func putGzipWriter(gz *gzip.Writer) { gzipWriterPool.Put(gz) }
+
+func getBrotliWriter(w io.Writer) *brotli.Writer {
+	bw := brotliWriterPool.Get().(*brotli.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+func putBrotliWriter(bw *brotli.Writer) { brotliWriterPool.Put(bw) }
+
+func getZstdEncoder(w io.Writer) *zstd.Encoder {
+	zw := zstdEncoderPool.Get().(*zstd.Encoder)
+	zw.Reset(w)
+	return zw
+}
+
+func putZstdEncoder(zw *zstd.Encoder) { zstdEncoderPool.Put(zw) }
+
+// parseAcceptEncoding разбирает заголовок Accept-Encoding (включая q-значения) в
+// карту "кодировка -> вес". Кодировки без явного q= считаются равными 1.
+func parseAcceptEncoding(header string) map[string]float64 {
+	weights := make(map[string]float64)
+	if header == "" {
+		return weights
+	}
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.SplitN(strings.TrimSpace(part), ";", 2)
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		if len(fields) == 2 {
+			if qStr, ok := strings.CutPrefix(strings.TrimSpace(fields[1]), "q="); ok {
+				if parsed, err := strconv.ParseFloat(qStr, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		weights[name] = q
+	}
+
+	return weights
+}
+
+// negotiateEncoding выбирает лучшую кодировку сжатия для ответа: br > gzip > zstd,
+// с учётом q-значений клиента и wildcard (`*`). Флаг -compression, если он не "auto",
+// переопределяет согласование и форсирует заданный оператором режим.
+func negotiateEncoding(r *http.Request) string {
+	switch config.Compression {
+	case "none":
+		return "identity"
+	case "gzip", "br":
+		return config.Compression
+	}
+
+	accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+	if len(accepted) == 0 {
+		return "identity"
+	}
+
+	wildcardQ, hasWildcard := accepted["*"]
+
+	best := "identity"
+	bestQ := 0.0
+	for _, enc := range []string{"br", "gzip", "zstd"} {
+		q, ok := accepted[enc]
+		if !ok && hasWildcard {
+			q, ok = wildcardQ, true
+		}
+		if ok && q > bestQ {
+			best = enc
+			bestQ = q
+		}
+	}
+
+	return best
+}
+
+// writeCompressed пишет data в w, используя выбранную кодировку сжатия
+func writeCompressed(w io.Writer, enc string, data []byte) error {
+	switch enc {
+	case "br":
+		bw := getBrotliWriter(w)
+		_, err := bw.Write(data)
+		closeErr := bw.Close()
+		putBrotliWriter(bw)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	case "zstd":
+		zw := getZstdEncoder(w)
+		_, err := zw.Write(data)
+		closeErr := zw.Close()
+		putZstdEncoder(zw)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	default: // gzip
+		gz := getGzipWriter(w)
+		_, err := gz.Write(data)
+		closeErr := gz.Close()
+		putGzipWriter(gz)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	}
+}
+
 func handleIndex(w http.ResponseWriter, r *http.Request) {
 	data := TemplateData{
 		LogDirectory: config.LogDirectory,
@@ -241,14 +477,30 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Vary", "Accept-Encoding")
 
-	// Всегда используем сжатие для HTML
-	w.Header().Set("Content-Encoding", "gzip")
-	gz := gzip.NewWriter(w)
-	defer gz.Close()
+	if checkNotModified(w, r, indexETag, serverStartTime, false) {
+		return
+	}
 
-	if err := tmpl.Execute(gz, data); err != nil {
+	enc := negotiateEncoding(r)
+	if enc == "identity" {
+		if err := tmpl.Execute(w, data); err != nil {
+			log.Printf("❌ Ошибка выполнения шаблона: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Encoding", enc)
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
 		log.Printf("❌ Ошибка выполнения шаблона: %v", err)
+		return
+	}
+
+	if err := writeCompressed(w, enc, buf.Bytes()); err != nil {
+		log.Printf("❌ Ошибка записи сжатых данных: %v", err)
 	}
 }
 
@@ -262,7 +514,7 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 		Encoding:    config.Encoding,
 	}
 
-	sendJSONResponse(w, r, response)
+	sendJSONResponse(w, r, response, http.StatusOK)
 }
 
 func handleConfig(w http.ResponseWriter, r *http.Request) {
@@ -275,7 +527,7 @@ func handleConfig(w http.ResponseWriter, r *http.Request) {
 		"startTime":    time.Now().Format(time.RFC3339),
 	}
 
-	sendJSONResponse(w, r, response)
+	sendJSONResponse(w, r, response, http.StatusOK)
 }
 
 func handleFileList(w http.ResponseWriter, r *http.Request) {
@@ -286,12 +538,15 @@ func handleFileList(w http.ResponseWriter, r *http.Request) {
 	// Проверяем существование директории
 	if _, err := os.Stat(config.LogDirectory); os.IsNotExist(err) {
 		response.Error = fmt.Sprintf("Каталог не существует: %s", config.LogDirectory)
-		w.WriteHeader(http.StatusNotFound)
-		sendJSONResponse(w, r, response)
+		sendJSONResponse(w, r, response, http.StatusNotFound)
 		return
 	}
 
-	// Читаем файлы рекурсивно
+	// Читаем файлы рекурсивно, одновременно хэшируя (path,size,mtime) каждого файла —
+	// порядок обхода filepath.Walk лексикографический и детерминированный, поэтому
+	// хэш можно считать потоково, не собирая список заранее
+	hasher := sha256.New()
+	var latestModTime time.Time
 	err := filepath.Walk(config.LogDirectory, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -325,43 +580,434 @@ func handleFileList(w http.ResponseWriter, r *http.Request) {
 			FullPath:  relPath,
 		}
 
+		fmt.Fprintf(hasher, "%s|%d|%d\n", relPath, info.Size(), info.ModTime().UnixNano())
+		if info.ModTime().After(latestModTime) {
+			latestModTime = info.ModTime()
+		}
 		response.Files = append(response.Files, fileInfo)
 		return nil
 	})
 
 	if err != nil {
 		response.Error = fmt.Sprintf("Ошибка чтения директории: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		sendJSONResponse(w, r, response, http.StatusInternalServerError)
+		return
 	}
 
-	sendJSONResponse(w, r, response)
+	if latestModTime.IsZero() {
+		latestModTime = serverStartTime
+	}
+
+	etag := fmt.Sprintf("\"%x\"", hasher.Sum(nil))
+	if checkNotModified(w, r, etag, latestModTime, false) {
+		return
+	}
+
+	sendJSONResponse(w, r, response, http.StatusOK)
 }
 
-func handleFileContent(w http.ResponseWriter, r *http.Request) {
-	filePath := r.URL.Query().Get("path")
+// pathError — ошибка проверки пути к файлу вместе с HTTP-статусом, который нужно вернуть
+type pathError struct {
+	status  int
+	message string
+}
+
+func (e *pathError) Error() string { return e.message }
+
+// resolveLogPath проверяет, что запрошенный файл находится внутри config.LogDirectory,
+// и возвращает абсолютный путь к нему. Используется всеми обработчиками, работающими
+// с конкретным файлом, чтобы не дублировать проверку на path traversal.
+func resolveLogPath(filePath string) (string, *pathError) {
 	if filePath == "" {
-		http.Error(w, "Не указан путь к файлу", http.StatusBadRequest)
-		return
+		return "", &pathError{http.StatusBadRequest, "Не указан путь к файлу"}
 	}
 
-	// Безопасная проверка пути
 	fullPath := filepath.Join(config.LogDirectory, filePath)
 
-	// Проверяем, что путь находится внутри LogDirectory
 	cleanPath, err := filepath.Abs(fullPath)
 	if err != nil {
-		http.Error(w, "Некорректный путь", http.StatusBadRequest)
-		return
+		return "", &pathError{http.StatusBadRequest, "Некорректный путь"}
 	}
 
 	cleanDir, err := filepath.Abs(config.LogDirectory)
 	if err != nil {
-		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return "", &pathError{http.StatusInternalServerError, "Ошибка сервера"}
+	}
+
+	if cleanPath != cleanDir && !strings.HasPrefix(cleanPath, cleanDir+string(os.PathSeparator)) {
+		return "", &pathError{http.StatusForbidden, "Доступ запрещен"}
+	}
+
+	return fullPath, nil
+}
+
+// lineBoundaryScanWindow — сколько байт сканировать в поисках перевода строки при
+// подгонке границы диапазона под безопасную для многобайтовых кодировок позицию
+const lineBoundaryScanWindow = 4096
+
+// resolveContentRange вычисляет полуинтервал [start, end) байт файла, которые нужно
+// отдать клиенту. Источник диапазона, в порядке приоритета: ?tail=N, заголовок Range,
+// ?offset=&length=&anchor=, и по умолчанию — последние config.PreviewSize байт (или
+// весь файл, если он меньше). explicit отличает явно запрошенный клиентом диапазон
+// от диапазона по умолчанию — только для последнего подставляется сообщение-предупреждение.
+func resolveContentRange(r *http.Request, size int64) (start, end int64, explicit bool, err error) {
+	q := r.URL.Query()
+
+	if tailStr := q.Get("tail"); tailStr != "" {
+		n, perr := strconv.ParseInt(tailStr, 10, 64)
+		if perr != nil || n < 0 {
+			return 0, 0, false, fmt.Errorf("некорректное значение tail")
+		}
+		start = size - n
+		if start < 0 {
+			start = 0
+		}
+		return start, size, true, nil
+	}
+
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, end, err = parseHTTPRange(rangeHeader, size)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return start, end, true, nil
+	}
+
+	if offsetStr, lengthStr := q.Get("offset"), q.Get("length"); offsetStr != "" || lengthStr != "" {
+		offset, perr := strconv.ParseInt(offsetStr, 10, 64)
+		if offsetStr != "" && perr != nil {
+			return 0, 0, false, fmt.Errorf("некорректное значение offset")
+		}
+
+		length := int64(-1)
+		if lengthStr != "" {
+			l, perr := strconv.ParseInt(lengthStr, 10, 64)
+			if perr != nil || l < 0 {
+				return 0, 0, false, fmt.Errorf("некорректное значение length")
+			}
+			length = l
+		}
+
+		if q.Get("anchor") == "end" {
+			start = size - offset
+			if length >= 0 {
+				start -= length
+			}
+		} else {
+			start = offset
+		}
+		if start < 0 {
+			start = 0
+		}
+		if start > size {
+			start = size
+		}
+
+		end = size
+		if length >= 0 && start+length < size {
+			end = start + length
+		}
+		return start, end, true, nil
+	}
+
+	// Диапазон не указан явно — используем PreviewSize как окно по умолчанию
+	if size > config.PreviewSize {
+		return size - config.PreviewSize, size, false, nil
+	}
+	return 0, size, false, nil
+}
+
+// parseHTTPRange разбирает заголовок `Range: bytes=start-end` (или суффиксную форму
+// `bytes=-N`). Поддерживается только один диапазон — для просмотра логов этого достаточно.
+func parseHTTPRange(header string, size int64) (int64, int64, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return 0, 0, fmt.Errorf("неподдерживаемая единица измерения Range")
+	}
+
+	spec := strings.Split(strings.TrimPrefix(header, prefix), ",")[0]
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("некорректный формат Range")
+	}
+
+	if parts[0] == "" {
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("некорректный суффиксный Range")
+		}
+		start := size - n
+		if start < 0 {
+			start = 0
+		}
+		return start, size, nil
+	}
+
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, fmt.Errorf("начало диапазона вне границ файла")
+	}
+
+	end := size
+	if parts[1] != "" {
+		e, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || e < start {
+			return 0, 0, fmt.Errorf("некорректный конец диапазона")
+		}
+		if e+1 < size {
+			end = e + 1
+		}
+	}
+
+	return start, end, nil
+}
+
+// snapToLineBoundary сдвигает границу диапазона наружу до ближайшего перевода строки,
+// сканируя не более lineBoundaryScanWindow байт. Нужно для кодировок, отличных от
+// UTF-8: иначе граница диапазона может разрезать многобайтовую последовательность пополам.
+func snapToLineBoundary(file *os.File, pos, size int64, isStart bool) int64 {
+	if pos <= 0 || pos >= size {
+		return pos
+	}
+
+	if isStart {
+		scanFrom := pos - lineBoundaryScanWindow
+		if scanFrom < 0 {
+			scanFrom = 0
+		}
+		buf := make([]byte, pos-scanFrom)
+		if _, err := file.ReadAt(buf, scanFrom); err != nil && err != io.EOF {
+			return pos
+		}
+		if idx := bytes.LastIndexByte(buf, '\n'); idx >= 0 {
+			return scanFrom + int64(idx) + 1
+		}
+		return scanFrom
+	}
+
+	scanTo := pos + lineBoundaryScanWindow
+	if scanTo > size {
+		scanTo = size
+	}
+	buf := make([]byte, scanTo-pos)
+	if _, err := file.ReadAt(buf, pos); err != nil && err != io.EOF {
+		return pos
+	}
+	if idx := bytes.IndexByte(buf, '\n'); idx >= 0 {
+		return pos + int64(idx) + 1
+	}
+	return scanTo
+}
+
+// encodingDetectSampleSize — сколько байт файла читать для определения кодировки
+const encodingDetectSampleSize = 64 * 1024
+
+// encodingCacheKey — слепок файла, с которым связан результат определения кодировки.
+// Изменение mtime или size означает, что файл изменился, и кэш для него невалиден.
+type encodingCacheKey struct {
+	path  string
+	mtime int64
+	size  int64
+}
+
+// encodingCache — простой LRU на базе container/list для результатов detectEncoding,
+// чтобы повторные запросы к одному и тому же файлу не пересканировали его каждый раз
+type encodingCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[encodingCacheKey]*list.Element
+}
+
+type encodingCacheEntry struct {
+	key   encodingCacheKey
+	value string
+}
+
+func newEncodingCache(capacity int) *encodingCache {
+	return &encodingCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[encodingCacheKey]*list.Element),
+	}
+}
+
+func (c *encodingCache) get(key encodingCacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*encodingCacheEntry).value, true
+}
+
+func (c *encodingCache) set(key encodingCacheKey, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*encodingCacheEntry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+
+	el := c.order.PushFront(&encodingCacheEntry{key: key, value: value})
+	c.items[key] = el
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*encodingCacheEntry).key)
+		}
+	}
+}
+
+var detectedEncodingCache = newEncodingCache(256)
+
+// detectEncoding определяет кодировку файла, кэшируя результат по (путь, mtime, size)
+func detectEncoding(fullPath string, info os.FileInfo) string {
+	key := encodingCacheKey{path: fullPath, mtime: info.ModTime().UnixNano(), size: info.Size()}
+
+	if cached, ok := detectedEncodingCache.get(key); ok {
+		return cached
+	}
+
+	detected := detectEncodingUncached(fullPath)
+	detectedEncodingCache.set(key, detected)
+	return detected
+}
+
+// detectEncodingUncached читает первые ~64 КиБ файла: если это валидный UTF-8 —
+// готово, иначе перебирает кандидатов 8-битных кодировок и выбирает ту, при
+// декодировании которой получается больше всего «ожидаемых» букв (кириллица для
+// русских кодировок, латиница-1 для iso-8859-1). При равенстве или отсутствии
+// уверенного кандидата возвращает кодировку по умолчанию из конфига.
+func detectEncodingUncached(fullPath string) string {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return config.Encoding
+	}
+	defer file.Close()
+
+	sample := make([]byte, encodingDetectSampleSize)
+	n, err := io.ReadFull(file, sample)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return config.Encoding
+	}
+	sample = sample[:n]
+
+	if utf8.Valid(sample) {
+		return "utf-8"
+	}
+
+	best := config.Encoding
+	bestScore := 0
+	tie := false
+
+	for _, name := range []string{"windows-1251", "koi8-r", "cp866", "iso-8859-1"} {
+		enc := encodings[name]
+		if enc == nil {
+			continue
+		}
+
+		decoded, err := enc.NewDecoder().Bytes(sample)
+		if err != nil {
+			continue
+		}
+
+		score := countExpectedLetters(name, decoded)
+		if score > bestScore {
+			best = name
+			bestScore = score
+			tie = false
+		} else if score == bestScore && bestScore > 0 {
+			tie = true
+		}
+	}
+
+	if tie {
+		return config.Encoding
+	}
+	return best
+}
+
+// countExpectedLetters считает руны декодированного текста, попадающие в диапазон,
+// ожидаемый для данной кодировки: кириллический блок U+0400-U+04FF для русских
+// 8-битных кодировок, латиница-1 (с диакритикой) для iso-8859-1.
+func countExpectedLetters(name string, decoded []byte) int {
+	count := 0
+	for _, r := range string(decoded) {
+		if name == "iso-8859-1" {
+			if r >= 0x00C0 && r <= 0x00FF {
+				count++
+			}
+			continue
+		}
+		if r >= 0x0400 && r <= 0x04FF {
+			count++
+		}
+	}
+	return count
+}
+
+// resolveRequestEncoding определяет, какую кодировку использовать для конкретного
+// запроса: ?encoding=auto запускает автоопределение, конкретное имя кодировки
+// переопределяет config.Encoding на один вызов, отсутствие параметра — поведение
+// по умолчанию. detected=true означает, что имя было получено автоопределением
+// (используется, чтобы решить, отдавать ли заголовок X-Detected-Encoding).
+func resolveRequestEncoding(r *http.Request, fullPath string, info os.FileInfo) (name string, detected bool, perr *pathError) {
+	requested := r.URL.Query().Get("encoding")
+	if requested == "" {
+		return config.Encoding, false, nil
+	}
+
+	if requested == "auto" {
+		return detectEncoding(fullPath, info), true, nil
+	}
+
+	if _, ok := encodings[requested]; !ok {
+		return "", false, &pathError{http.StatusBadRequest, fmt.Sprintf("Неподдерживаемая кодировка: %s", requested)}
+	}
+
+	return requested, false, nil
+}
+
+// handleDetectEncoding запускает автоопределение кодировки файла и возвращает
+// результат, не читая при этом содержимое файла целиком
+func handleDetectEncoding(w http.ResponseWriter, r *http.Request) {
+	filePath := r.URL.Query().Get("path")
+
+	fullPath, perr := resolveLogPath(filePath)
+	if perr != nil {
+		http.Error(w, perr.message, perr.status)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, "Файл не найден", http.StatusNotFound)
 		return
 	}
 
-	if !strings.HasPrefix(cleanPath, cleanDir) {
-		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+	detected := detectEncoding(fullPath, info)
+
+	w.Header().Set("X-Detected-Encoding", detected)
+	sendJSONResponse(w, r, map[string]string{
+		"path":     filePath,
+		"encoding": detected,
+	}, http.StatusOK)
+}
+
+func handleFileContent(w http.ResponseWriter, r *http.Request) {
+	filePath := r.URL.Query().Get("path")
+
+	fullPath, perr := resolveLogPath(filePath)
+	if perr != nil {
+		http.Error(w, perr.message, perr.status)
 		return
 	}
 
@@ -380,53 +1026,70 @@ func handleFileContent(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Подготовка сообщения о предпросмотре (в UTF-8)
-	var message []byte
-	var fileContent []byte
+	size := info.Size()
 
-	if info.Size() > config.PreviewSize {
-		// Формируем сообщение о предпросмотре в UTF-8
-		message = []byte(fmt.Sprintf("⚠️ Файл слишком большой (%.2f МБ). Показаны последние %d байт (%.2f МБ). Полный файл доступен для скачивания.\n",
-			float64(info.Size())/(1024*1024), config.PreviewSize, float64(config.PreviewSize)/(1024*1024)))
+	encodingName, detected, perr := resolveRequestEncoding(r, fullPath, info)
+	if perr != nil {
+		http.Error(w, perr.message, perr.status)
+		return
+	}
 
-		// Читаем только последние config.PreviewSize байт из файла
-		offset := info.Size() - config.PreviewSize
-		if offset < 0 {
-			offset = 0
-		}
+	start, end, explicit, err := resolveContentRange(r, size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
 
-		// Перемещаем указатель
-		_, err = file.Seek(offset, io.SeekStart)
-		if err != nil {
-			http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
-			return
-		}
+	// Конвертация в нестандартную кодировку может разрезать многобайтовую
+	// последовательность на границе диапазона — подвинем границы до ближайшей строки
+	if encodingName != "utf-8" && encodings[encodingName] != nil {
+		start = snapToLineBoundary(file, start, size, true)
+		end = snapToLineBoundary(file, end, size, false)
+	}
 
-		// Читаем данные
-		fileContent = make([]byte, config.PreviewSize)
-		n, err := io.ReadFull(file, fileContent)
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
-			return
-		}
-		fileContent = fileContent[:n]
-	} else {
-		// Читаем весь файл
-		fileContent, err = io.ReadAll(file)
-		if err != nil {
-			http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
-			return
-		}
+	// Строгий ETag захватывает точный диапазон и используемый декодер — изменение
+	// любого из них (в т.ч. через Range/?tail/?offset/?encoding) должно инвалидировать кэш клиента.
+	// requireETag=true: mtime файла ничего не говорит о том, совпадает ли запрошенный
+	// диапазон/кодировка с тем, что кэшировал клиент, поэтому If-Modified-Since один,
+	// без подтверждающего If-None-Match, здесь 304 не отдаёт
+	etag := fmt.Sprintf("\"%d-%d-%d-%d-%s\"", size, info.ModTime().UnixNano(), start, end-start, encodingName)
+	if checkNotModified(w, r, etag, info.ModTime(), true) {
+		return
+	}
+
+	// Предупреждение показываем только тогда, когда диапазон подставлен нами,
+	// а не запрошен клиентом явно — так сохраняется сегодняшнее поведение UI.
+	// Раз оно примешивается к телу, такой ответ не может притворяться байт-точным
+	// диапазоном: ниже он всегда уходит как 200, а не 206
+	var message []byte
+	if !explicit && size > config.PreviewSize {
+		message = []byte(fmt.Sprintf("⚠️ Файл слишком большой (%.2f МБ). Показаны последние %d байт (%.2f МБ). Полный файл доступен для скачивания.\n",
+			float64(size)/(1024*1024), config.PreviewSize, float64(config.PreviewSize)/(1024*1024)))
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
+		return
+	}
+
+	fileContent := make([]byte, end-start)
+	n, err := io.ReadFull(file, fileContent)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		http.Error(w, "Ошибка чтения файла", http.StatusInternalServerError)
+		return
 	}
+	fileContent = fileContent[:n]
+	end = start + int64(n)
 
 	// Сначала конвертируем содержимое файла в UTF-8 если нужно
 	var convertedContent []byte
-	if config.Encoding != "utf-8" && encodings[config.Encoding] != nil {
-		decoder := encodings[config.Encoding].NewDecoder()
+	if encodingName != "utf-8" && encodings[encodingName] != nil {
+		decoder := encodings[encodingName].NewDecoder()
 		converted, err := decoder.Bytes(fileContent)
 		if err != nil {
 			// Если не удалось конвертировать, оставляем как есть
-			log.Printf("⚠️ Не удалось конвертировать файл %s из %s в UTF-8: %v", filepath.Base(filePath), config.Encoding, err)
+			log.Printf("⚠️ Не удалось конвертировать файл %s из %s в UTF-8: %v", filepath.Base(filePath), encodingName, err)
 			convertedContent = fileContent
 		} else {
 			convertedContent = converted
@@ -436,42 +1099,239 @@ func handleFileContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Объединяем сообщение (уже в UTF-8) с конвертированным содержимым файла
-	var finalContent []byte
-	if info.Size() > config.PreviewSize {
-		finalContent = append(message, convertedContent...)
-	} else {
-		finalContent = convertedContent
+	finalContent := append(message, convertedContent...)
+
+	lastByte := end - 1
+	if lastByte < start {
+		lastByte = start
+	}
+
+	// 206 только для явно запрошенного клиентом диапазона: тело в этом случае —
+	// точно байты [start, end), и Content-Range обязан им соответствовать. Диапазон
+	// по умолчанию (превью большого файла) может нести предупреждение впереди тела,
+	// так что он остаётся 200 и не заявляет byte-range семантику, которой не следует
+	status := http.StatusOK
+	if explicit {
+		status = http.StatusPartialContent
 	}
 
 	// Устанавливаем заголовки и отправляем
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	sendCompressed(w, r, finalContent)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if explicit {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, lastByte, size))
+	}
+	if detected {
+		w.Header().Set("X-Detected-Encoding", encodingName)
+	}
+	sendCompressed(w, r, finalContent, status)
 }
 
-func handleFileDownload(w http.ResponseWriter, r *http.Request) {
+// decodeTailChunk конвертирует прочитанный кусок файла в UTF-8 согласно config.Encoding
+func decodeTailChunk(chunk []byte) []byte {
+	if config.Encoding == "utf-8" || encodings[config.Encoding] == nil {
+		return chunk
+	}
+
+	decoded, err := encodings[config.Encoding].NewDecoder().Bytes(chunk)
+	if err != nil {
+		return chunk
+	}
+	return decoded
+}
+
+// handleTail стримит новые байты, дописываемые в лог-файл, через Server-Sent Events
+// (аналог `tail -f`). Клиент переподключается с заголовком Last-Event-ID, чтобы
+// продолжить с того же места, на котором остановился.
+func handleTail(w http.ResponseWriter, r *http.Request) {
 	filePath := r.URL.Query().Get("path")
-	if filePath == "" {
-		http.Error(w, "Не указан путь к файлу", http.StatusBadRequest)
+
+	fullPath, perr := resolveLogPath(filePath)
+	if perr != nil {
+		http.Error(w, perr.message, perr.status)
 		return
 	}
 
-	// Безопасная проверка пути (используем ту же функцию что и выше)
-	fullPath := filepath.Join(config.LogDirectory, filePath)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, "Файл не найден", http.StatusNotFound)
+		return
+	}
 
-	cleanPath, err := filepath.Abs(fullPath)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(fullPath)
 	if err != nil {
-		http.Error(w, "Некорректный путь", http.StatusBadRequest)
+		http.Error(w, "Ошибка открытия файла", http.StatusInternalServerError)
 		return
 	}
+	// file переоткрывается на ротации внутри readNewBytes — замыкание гарантирует,
+	// что закрывается актуальный дескриптор, а не тот, что был открыт при входе в хендлер
+	defer func() { file.Close() }()
+
+	// Стартовая позиция: по умолчанию — конец файла (только новые записи)
+	offset := info.Size()
+	if fromEndStr := r.URL.Query().Get("fromEnd"); fromEndStr != "" {
+		if n, err := strconv.ParseInt(fromEndStr, 10, 64); err == nil && n >= 0 {
+			offset = info.Size() - n
+			if offset < 0 {
+				offset = 0
+			}
+		}
+	}
 
-	cleanDir, err := filepath.Abs(config.LogDirectory)
+	// Last-Event-ID имеет приоритет над fromEnd — браузер просит продолжить с точного байта
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		if n, err := strconv.ParseInt(lastID, 10, 64); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		http.Error(w, "Не удалось запустить слежение за файлом", http.StatusInternalServerError)
 		return
 	}
+	defer watcher.Close()
 
-	if !strings.HasPrefix(cleanPath, cleanDir) {
-		http.Error(w, "Доступ запрещен", http.StatusForbidden)
+	// Следим за директорией, а не за самим файлом: при ротации (rename/remove)
+	// inode файла меняется, и watch на сам файл после этого перестаёт работать
+	if err := watcher.Add(filepath.Dir(fullPath)); err != nil {
+		http.Error(w, "Не удалось запустить слежение за файлом", http.StatusInternalServerError)
+		return
+	}
+
+	// Снимаем WriteTimeout сервера: SSE-соединение живёт неограниченно долго,
+	// а не укладывается в обычные 15с на запись ответа
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("⚠️ Не удалось снять дедлайн записи для SSE: %v", err)
+	}
+
+	// Эта функция НЕ сжимается gzip: SSE требует, чтобы каждый flush доходил
+	// до клиента немедленно, а буферизация компрессора это ломает
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sendEvent := func(event string, id int64, data []byte) {
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		fmt.Fprintf(w, "id: %d\n", id)
+		for _, line := range strings.Split(string(data), "\n") {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		fmt.Fprint(w, "\n")
+		flusher.Flush()
+	}
+
+	// readNewBytes читает всё, что появилось в файле после offset, и шлёт клиенту.
+	// Если файл сжался или был пересоздан (ротация по логротейту), сообщает об этом
+	// событием "rotated" и начинает читать новый файл с начала. Ротация детектируется
+	// не только по уменьшению размера (который может не успеть отследиться между
+	// опросами), но и по смене inode/устройства — на случай, если новый файл уже
+	// дорос до размера старого или превысил его к моменту следующего Stat
+	readNewBytes := func() error {
+		stat, err := os.Stat(fullPath)
+		if err != nil {
+			return err
+		}
+
+		curInfo, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		if stat.Size() < offset || !os.SameFile(stat, curInfo) {
+			sendEvent("rotated", offset, nil)
+
+			newFile, err := os.Open(fullPath)
+			if err != nil {
+				return err
+			}
+			file.Close()
+			file = newFile
+			offset = 0
+
+			stat, err = file.Stat()
+			if err != nil {
+				return err
+			}
+		}
+
+		if stat.Size() <= offset {
+			return nil
+		}
+
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		chunk := make([]byte, stat.Size()-offset)
+		n, err := io.ReadFull(file, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		chunk = chunk[:n]
+		offset += int64(n)
+
+		sendEvent("", offset, decodeTailChunk(chunk))
+		return nil
+	}
+
+	if err := readNewBytes(); err != nil {
+		log.Printf("⚠️ Ошибка чтения %s при старте tail: %v", filePath, err)
+	}
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shutdownCh:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(fullPath) {
+				continue
+			}
+
+			switch {
+			case event.Op&fsnotify.Write == fsnotify.Write:
+				if err := readNewBytes(); err != nil {
+					log.Printf("⚠️ Ошибка чтения %s: %v", filePath, err)
+					return
+				}
+			case event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+				// Файл переименован/удалён логротейтом — новый файл может появиться
+				// с небольшой задержкой, поэтому не выходим, а продолжаем слежение за директорией
+				if err := readNewBytes(); err != nil {
+					continue
+				}
+			}
+		case werr, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("⚠️ Ошибка fsnotify при слежении за %s: %v", filePath, werr)
+		}
+	}
+}
+
+func handleFileDownload(w http.ResponseWriter, r *http.Request) {
+	filePath := r.URL.Query().Get("path")
+
+	fullPath, perr := resolveLogPath(filePath)
+	if perr != nil {
+		http.Error(w, perr.message, perr.status)
 		return
 	}
 
@@ -493,14 +1353,310 @@ func handleFileDownload(w http.ResponseWriter, r *http.Request) {
 	// Устанавливаем заголовки для скачивания
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", info.Name()))
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	etag := fmt.Sprintf("\"%d-%d-0-%d-raw\"", info.Size(), info.ModTime().UnixNano(), info.Size())
+	if checkNotModified(w, r, etag, info.ModTime(), false) {
+		return
+	}
+
+	// Уже сжатые форматы не сжимаем повторно
+	enc := "identity"
+	if !isCompressedExt(info.Name()) {
+		enc = negotiateEncoding(r)
+	}
+
+	if enc == "identity" {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
+		io.Copy(w, file)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", enc)
+	if err := writeCompressedStream(w, enc, file); err != nil {
+		log.Printf("❌ Ошибка сжатия при скачивании %s: %v", filePath, err)
+	}
+}
+
+// isCompressedExt определяет по расширению, что файл уже сжат, и повторное
+// сжатие при скачивании только потратит CPU без выигрыша в размере
+func isCompressedExt(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".zip", ".br", ".zst":
+		return true
+	default:
+		return false
+	}
+}
+
+// writeCompressedStream сжимает поток (в отличие от writeCompressed, который
+// сжимает уже готовый срез байт) — нужен для скачивания больших файлов без
+// буферизации всего содержимого в памяти
+func writeCompressedStream(w io.Writer, enc string, src io.Reader) error {
+	switch enc {
+	case "br":
+		bw := getBrotliWriter(w)
+		_, err := io.Copy(bw, src)
+		closeErr := bw.Close()
+		putBrotliWriter(bw)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	case "zstd":
+		zw := getZstdEncoder(w)
+		_, err := io.Copy(zw, src)
+		closeErr := zw.Close()
+		putZstdEncoder(zw)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	default: // gzip
+		gz := getGzipWriter(w)
+		_, err := io.Copy(gz, src)
+		closeErr := gz.Close()
+		putGzipWriter(gz)
+		if err != nil {
+			return err
+		}
+		return closeErr
+	}
+}
+
+// archiveRequest — тело POST-запроса к /api/archive
+type archiveRequest struct {
+	Paths  []string `json:"paths"`
+	Format string   `json:"format"`
+}
+
+// archiveEntry описывает один файл, попадающий в архив
+type archiveEntry struct {
+	relPath  string
+	fullPath string
+	info     os.FileInfo
+}
+
+// collectArchiveEntries резолвит список запрошенных путей (файлов или каталогов) в
+// плоский список файлов для архивации, рекурсивно раскрывая каталоги. Использует ту
+// же защиту от path traversal, что и остальные обработчики файлов.
+func collectArchiveEntries(paths []string) ([]archiveEntry, *pathError) {
+	var entries []archiveEntry
+	seen := make(map[string]bool)
+
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		fullPath, perr := resolveLogPath(p)
+		if perr != nil {
+			return nil, perr
+		}
+
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return nil, &pathError{http.StatusNotFound, fmt.Sprintf("Файл не найден: %s", p)}
+		}
 
-	// Копируем файл в response
-	io.Copy(w, file)
+		if !info.IsDir() {
+			rel, err := filepath.Rel(config.LogDirectory, fullPath)
+			if err != nil {
+				return nil, &pathError{http.StatusInternalServerError, "Ошибка сервера"}
+			}
+			if !seen[rel] {
+				seen[rel] = true
+				entries = append(entries, archiveEntry{relPath: rel, fullPath: fullPath, info: info})
+			}
+			continue
+		}
+
+		walkErr := filepath.Walk(fullPath, func(walkPath string, winfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if winfo.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(config.LogDirectory, walkPath)
+			if err != nil {
+				return err
+			}
+			if seen[rel] {
+				return nil
+			}
+			seen[rel] = true
+			entries = append(entries, archiveEntry{relPath: rel, fullPath: walkPath, info: winfo})
+			return nil
+		})
+		if walkErr != nil {
+			return nil, &pathError{http.StatusInternalServerError, fmt.Sprintf("Ошибка чтения каталога %s: %v", p, walkErr)}
+		}
+	}
+
+	return entries, nil
 }
 
-// Отправка JSON с сжатием
-func sendJSONResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
+// sendArchiveLimitError отвечает 413 с JSON-описанием превышенного лимита
+func sendArchiveLimitError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// copyFileInto копирует содержимое fullPath в dst — используется при упаковке архива
+func copyFileInto(dst io.Writer, fullPath string) error {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(dst, file)
+	return err
+}
+
+// writeZipArchive пишет entries как zip прямо в w, без буферизации архива в памяти
+func writeZipArchive(w io.Writer, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	for _, e := range entries {
+		header, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(e.relPath)
+		header.Method = zip.Deflate
+		header.Modified = e.info.ModTime()
+
+		entryWriter, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFileInto(entryWriter, e.fullPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeTarGzArchive пишет entries как tar.gz прямо в w, без буферизации архива в памяти
+func writeTarGzArchive(w io.Writer, entries []archiveEntry) error {
+	gzWriter := gzip.NewWriter(w)
+	defer gzWriter.Close()
+
+	tw := tar.NewWriter(gzWriter)
+	defer tw.Close()
+
+	for _, e := range entries {
+		header, err := tar.FileInfoHeader(e.info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(e.relPath)
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyFileInto(tw, e.fullPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleArchive стримит zip или tar.gz с набором запрошенных файлов/каталогов
+// (POST с JSON-телом {paths, format} или GET с ?paths=a,b,c&format=zip|tar.gz).
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	var paths []string
+	format := "zip"
+
+	switch r.Method {
+	case http.MethodGet:
+		if pathsParam := r.URL.Query().Get("paths"); pathsParam != "" {
+			paths = strings.Split(pathsParam, ",")
+		}
+		if f := r.URL.Query().Get("format"); f != "" {
+			format = f
+		}
+	case http.MethodPost:
+		var req archiveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Некорректное тело запроса", http.StatusBadRequest)
+			return
+		}
+		paths = req.Paths
+		if req.Format != "" {
+			format = req.Format
+		}
+	default:
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if format != "zip" && format != "tar.gz" {
+		http.Error(w, "Неподдерживаемый формат архива: "+format, http.StatusBadRequest)
+		return
+	}
+
+	if len(paths) == 0 {
+		http.Error(w, "Не указаны пути для архивации", http.StatusBadRequest)
+		return
+	}
+
+	entries, perr := collectArchiveEntries(paths)
+	if perr != nil {
+		http.Error(w, perr.message, perr.status)
+		return
+	}
+
+	if len(entries) > config.ArchiveMaxFiles {
+		sendArchiveLimitError(w, fmt.Sprintf("Слишком много файлов для архивации: %d (максимум %d)", len(entries), config.ArchiveMaxFiles))
+		return
+	}
+
+	var totalBytes int64
+	for _, e := range entries {
+		totalBytes += e.info.Size()
+	}
+	if totalBytes > config.ArchiveMaxBytes {
+		sendArchiveLimitError(w, fmt.Sprintf("Слишком большой суммарный размер: %.2f МБ (максимум %.2f МБ)",
+			float64(totalBytes)/(1024*1024), float64(config.ArchiveMaxBytes)/(1024*1024)))
+		return
+	}
+
+	filename := fmt.Sprintf("logs-%s.%s", time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	// Снимаем WriteTimeout сервера: архив может формироваться дольше 15с при
+	// -archive-max-bytes по умолчанию (500 МБ) и медленном клиенте
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("⚠️ Не удалось снять дедлайн записи для архива: %v", err)
+	}
+
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.WriteHeader(http.StatusOK)
+		if err := writeZipArchive(w, entries); err != nil {
+			log.Printf("❌ Ошибка формирования zip-архива: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.WriteHeader(http.StatusOK)
+	if err := writeTarGzArchive(w, entries); err != nil {
+		log.Printf("❌ Ошибка формирования tar.gz-архива: %v", err)
+	}
+}
+
+// Отправка JSON с сжатием; status — код ответа, который увидит клиент
+func sendJSONResponse(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
 	jsonData, err := json.Marshal(data)
@@ -509,18 +1665,24 @@ func sendJSONResponse(w http.ResponseWriter, r *http.Request, data interface{})
 		return
 	}
 
-	sendCompressed(w, r, jsonData)
+	sendCompressed(w, r, jsonData, status)
 }
 
 // Универсальная функция отправки сжатых данных (всегда сжимаем)
-func sendCompressed(w http.ResponseWriter, r *http.Request, data []byte) {
-	w.Header().Set("Content-Encoding", "gzip")
+func sendCompressed(w http.ResponseWriter, r *http.Request, data []byte, status int) {
 	w.Header().Set("Vary", "Accept-Encoding")
 
-	gz := gzip.NewWriter(w)
-	defer gz.Close()
+	enc := negotiateEncoding(r)
+	if enc == "identity" {
+		w.WriteHeader(status)
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", enc)
+	w.WriteHeader(status)
 
-	if _, err := gz.Write(data); err != nil {
+	if err := writeCompressed(w, enc, data); err != nil {
 		log.Printf("❌ Ошибка записи сжатых данных: %v", err)
 	}
 }
\ No newline at end of file